@@ -8,16 +8,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/go-elasticsearch"
-	"github.com/elastic/go-elasticsearch/esapi"
+	"github.com/elastic/go-elasticsearch/esutil"
 )
 
 type Article struct {
@@ -34,7 +36,6 @@ type Author struct {
 }
 
 var (
-	_     = fmt.Print
 	count int
 	batch int
 )
@@ -48,40 +49,12 @@ func init() {
 func main() {
 	log.SetFlags(0)
 
-	type bulkResponse struct {
-		Errors bool `json:"errors"`
-		Items  []struct {
-			Index struct {
-				ID     string `json:"_id"`
-				Result string `json:"result"`
-				Status int    `json:"status"`
-				Error  struct {
-					Type   string `json:"type"`
-					Reason string `json:"reason"`
-					Cause  struct {
-						Type   string `json:"type"`
-						Reason string `json:"reason"`
-					} `json:"caused_by"`
-				} `json:"error"`
-			} `json:"index"`
-		} `json:"items"`
-	}
-
 	var (
-		buf bytes.Buffer
-		res *esapi.Response
-		err error
-		raw map[string]interface{}
-		blk *bulkResponse
-
 		articles  []*Article
 		indexName = "articles"
 
-		numItems   int
-		numErrors  int
-		numIndexed int
-		currBatch  int
-		numBatches int
+		numIndexed int64
+		numErrors  int64
 	)
 
 	es, err := elasticsearch.NewDefaultClient()
@@ -110,11 +83,7 @@ func main() {
 	if _, err = es.Indices.Delete([]string{indexName}); err != nil {
 		log.Fatalf("Cannot delete index: %s", err)
 	}
-	res, err = es.Indices.Delete([]string{indexName})
-	if err != nil {
-		log.Fatalf("Cannot delete index: %s", err)
-	}
-	res, err = es.Indices.Create(indexName)
+	res, err := es.Indices.Create(indexName)
 	if err != nil {
 		log.Fatalf("Cannot create index: %s", err)
 	}
@@ -122,100 +91,61 @@ func main() {
 		log.Fatalf("Cannot create index: %s", res)
 	}
 
-	if count%batch == 0 {
-		numBatches = (count / batch)
-	} else {
-		numBatches = (count / batch) + 1
+	// Create the indexer
+	//
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Index:      indexName,
+		Client:     es,
+		NumWorkers: 4,
+		FlushBytes: 5e+6,
+	})
+	if err != nil {
+		log.Fatalf("Error creating the indexer: %s", err)
 	}
 
 	start := time.Now().UTC()
 
-	// Start looping over collection
+	// Add the articles to the indexer
 	//
-	for i, a := range articles {
-		numItems++
-
-		currBatch = i / batch
-		if i == count-1 {
-			currBatch++
-		}
-
-		// Prepare meta data
-		//
-		meta := []byte(fmt.Sprintf(`{ "index" : { "_index" : "%s", "_id" : "%d" } }%s`, indexName, a.ID, "\n"))
-		// fmt.Printf("%s", meta) // <-- Uncomment to see the payload
-
-		// Encode article to JSON
-		//
+	for _, a := range articles {
 		data, err := json.Marshal(a)
 		if err != nil {
 			log.Fatalf("Cannot encode article %d: %s", a.ID, err)
 		}
 
-		// Append newline to JSON payload
-		data = append(data, "\n"...) // <-- Comment out to trigger failure for batch
-		// fmt.Printf("%s", data) // <-- Uncomment to see the payload
-
-		// // Uncomment next block to trigger indexing errors -->
-		// if a.ID == 11 || a.ID == 101 {
-		// 	data = []byte(`{"published" : "INCORRECT"}` + "\n")
-		// }
-
-		// Append meta data and payload to the buffer (ignoring write errors)
-		//
-		buf.Grow(len(meta) + len(data))
-		buf.Write(meta)
-		buf.Write(data)
-
-		// When a threshold is reached, execute the Bulk() request with body from buffer
-		//
-		if i > 0 && i%batch == 0 || i == count-1 {
-			log.Printf("> Batch %-2d of %d", currBatch, numBatches)
-
-			res, err = es.Bulk(bytes.NewReader(buf.Bytes()), es.Bulk.WithIndex(indexName))
-			if err != nil {
-				log.Fatalf("Failure indexing batch %d: %s", currBatch, err)
-			}
-			// If the whole request failed, print error
-			if res.IsError() {
-				numErrors += numItems
-				if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
-					log.Fatalf("Failure to to parse response body: %s", err)
-				} else {
-					log.Printf("  Error: [%d] %s: %s",
-						res.StatusCode,
-						raw["error"].(map[string]interface{})["type"],
-						raw["error"].(map[string]interface{})["reason"],
-					)
-				}
-				// A successful response might still contain errors for particular documents.
-			} else {
-				if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
-					log.Fatalf("Failure to to parse response body: %s", err)
-				} else {
-					for _, d := range blk.Items {
-						if d.Index.Status > 201 {
-							numErrors++
-
-							// Print the response status and error information.
-							log.Printf("  Error: [%d]: %s: %s: %s: %s",
-								d.Index.Status,
-								d.Index.Error.Type,
-								d.Index.Error.Reason,
-								d.Index.Error.Cause.Type,
-								d.Index.Error.Cause.Reason,
-							)
-						} else {
-							numIndexed++
-						}
+		err = bi.Add(
+			context.Background(),
+			esutil.BulkIndexerItem{
+				Action:     "index",
+				DocumentID: strconv.Itoa(a.ID),
+				Body:       bytes.NewReader(data),
+
+				OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+					atomic.AddInt64(&numIndexed, 1)
+				},
+				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+					var vc *esutil.VersionConflictError
+					if errors.As(err, &vc) {
+						// Ignore version conflicts: a common pattern during reindex/CDC workloads.
+						return
 					}
-				}
-			}
-			buf.Reset()
-			numItems = 0
+
+					atomic.AddInt64(&numErrors, 1)
+					log.Printf("  Error: [%d]: %s", res.Status, err)
+				},
+			},
+		)
+		if err != nil {
+			log.Fatalf("Unexpected error: %s", err)
 		}
 	}
 
+	// Close the indexer, flushing and waiting for in-flight items
+	//
+	if err := bi.Close(context.Background()); err != nil {
+		log.Fatalf("Unexpected error: %s", err)
+	}
+
 	log.Println(strings.Repeat("=", 80))
 
 	dur := time.Since(start)
@@ -0,0 +1,235 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch"
+	"github.com/elastic/go-elasticsearch/esapi"
+)
+
+// TextExtractor pulls plain text (and metadata) out of a binary document,
+// out-of-process from Elasticsearch, e.g. via an Apache Tika server.
+//
+type TextExtractor interface {
+	Extract(ctx context.Context, content []byte) (ExtractedText, error)
+}
+
+// ExtractedText is the result of a TextExtractor.Extract call.
+//
+type ExtractedText struct {
+	Content     string
+	ContentType string
+	Language    string
+}
+
+// TikaTextExtractor is a TextExtractor backed by an Apache Tika server.
+//
+type TikaTextExtractor struct {
+	// URL is the base URL of the Tika server, e.g. "http://localhost:9998".
+	URL string
+	// Accept selects the response format: "text/plain" (default) or "text/html".
+	Accept string
+
+	Client *http.Client
+}
+
+// Extract implements the TextExtractor interface by PUTting content to the
+// Tika server's /tika endpoint.
+//
+func (te *TikaTextExtractor) Extract(ctx context.Context, content []byte) (ExtractedText, error) {
+	accept := te.Accept
+	if accept == "" {
+		accept = "text/plain"
+	}
+
+	client := te.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodPut, te.URL+"/tika", bytes.NewReader(content))
+	if err != nil {
+		return ExtractedText{}, fmt.Errorf("esutil: cannot create Tika request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", accept)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return ExtractedText{}, fmt.Errorf("esutil: Tika request failed: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return ExtractedText{}, fmt.Errorf("esutil: Tika server returned status %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ExtractedText{}, fmt.Errorf("esutil: cannot read Tika response: %s", err)
+	}
+
+	return ExtractedText{
+		Content:     string(body),
+		ContentType: res.Header.Get("Content-Type"),
+		Language:    res.Header.Get("Content-Language"),
+	}, nil
+}
+
+// AttachmentIndexerConfig represents configuration of AttachmentIndexer.
+//
+type AttachmentIndexerConfig struct {
+	Client    *elasticsearch.Client // The Elasticsearch client.
+	Indexer   BulkIndexer           // The underlying BulkIndexer used to index documents.
+	Extractor TextExtractor         // When set, text is extracted out-of-process instead of via an ingest pipeline.
+	Pipeline  string                // The `attachment` ingest pipeline name. Defaults to "attachment".
+	Field     string                // The field the base64-encoded content is stored in. Defaults to "data".
+
+	// OnExtractionFailure is called when Extractor fails to process a document,
+	// separately from OnFailure on the underlying BulkIndexerItem, which only
+	// covers indexing failures.
+	OnExtractionFailure func(ctx context.Context, documentID string, err error)
+}
+
+// AttachmentIndexer indexes binary documents (PDF, Word, etc.) into
+// Elasticsearch, either via the `attachment` ingest processor or by
+// extracting text out-of-process beforehand.
+//
+type AttachmentIndexer struct {
+	config AttachmentIndexerConfig
+
+	// putPipeline defaults to a direct call to config.Client.Ingest.PutPipeline,
+	// so tests can substitute a fake transport.
+	putPipeline func(id string, body io.Reader) (*esapi.Response, error)
+}
+
+// NewAttachmentIndexer creates a new AttachmentIndexer.
+//
+func NewAttachmentIndexer(cfg AttachmentIndexerConfig) (*AttachmentIndexer, error) {
+	if cfg.Indexer == nil {
+		return nil, fmt.Errorf("esutil: Indexer must be set")
+	}
+	if cfg.Client == nil {
+		cfg.Client, _ = elasticsearch.NewDefaultClient()
+	}
+	if cfg.Pipeline == "" {
+		cfg.Pipeline = "attachment"
+	}
+	if cfg.Field == "" {
+		cfg.Field = "data"
+	}
+
+	return &AttachmentIndexer{
+		config: cfg,
+		putPipeline: func(id string, body io.Reader) (*esapi.Response, error) {
+			return cfg.Client.Ingest.PutPipeline(id, body)
+		},
+	}, nil
+}
+
+// PutPipeline creates or updates the `attachment` ingest pipeline used when no
+// out-of-process Extractor is configured.
+//
+func (ai *AttachmentIndexer) PutPipeline(ctx context.Context) error {
+	body := map[string]interface{}{
+		"description": "Extract attachment information",
+		"processors": []map[string]interface{}{
+			{
+				"attachment": map[string]interface{}{
+					"field":        ai.config.Field,
+					"target_field": "attachment",
+				},
+			},
+		},
+	}
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("esutil: cannot encode ingest pipeline: %s", err)
+	}
+
+	res, err := ai.putPipeline(ai.config.Pipeline, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("esutil: cannot create ingest pipeline: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("esutil: cannot create ingest pipeline: %s", res.String())
+	}
+
+	return nil
+}
+
+// Add indexes a binary document. When config.Extractor is set, the document's
+// text is extracted out-of-process first and the plain text, content-type,
+// and language are stored alongside doc; otherwise, content is base64-encoded
+// and routed through the ingest pipeline.
+//
+func (ai *AttachmentIndexer) Add(ctx context.Context, documentID string, content []byte, doc map[string]interface{}, onSuccess func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem), onFailure func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem, err error)) error {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+
+	item := BulkIndexerItem{
+		Action:     "index",
+		DocumentID: documentID,
+		OnSuccess:  onSuccess,
+		OnFailure:  onFailure,
+	}
+
+	if ai.config.Extractor != nil {
+		extracted, err := ai.config.Extractor.Extract(ctx, content)
+		if err != nil {
+			if ai.config.OnExtractionFailure != nil {
+				ai.config.OnExtractionFailure(ctx, documentID, err)
+			}
+			return fmt.Errorf("esutil: text extraction failed: %s", err)
+		}
+
+		doc["content"] = extracted.Content
+		doc["content_type"] = extracted.ContentType
+		doc["language"] = extracted.Language
+
+		body, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("esutil: cannot encode document: %s", err)
+		}
+		item.Body = bytes.NewReader(body)
+	} else {
+		doc[ai.config.Field] = base64.StdEncoding.EncodeToString(content)
+
+		body, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("esutil: cannot encode document: %s", err)
+		}
+		item.Body = bytes.NewReader(body)
+		item.Pipeline = ai.config.Pipeline
+	}
+
+	return ai.config.Indexer.Add(ctx, item)
+}
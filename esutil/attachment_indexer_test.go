@@ -0,0 +1,203 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/esapi"
+)
+
+// recordingBulkIndexer is a BulkIndexer that records the items it was given,
+// used to exercise AttachmentIndexer in isolation.
+type recordingBulkIndexer struct {
+	items []BulkIndexerItem
+}
+
+func (r *recordingBulkIndexer) Add(ctx context.Context, item BulkIndexerItem) error {
+	r.items = append(r.items, item)
+	return nil
+}
+func (*recordingBulkIndexer) Close(ctx context.Context) error { return nil }
+func (*recordingBulkIndexer) Stats() BulkIndexerStats         { return BulkIndexerStats{} }
+
+// fakeTextExtractor is a TextExtractor returning a canned result or error.
+type fakeTextExtractor struct {
+	result ExtractedText
+	err    error
+}
+
+func (fe *fakeTextExtractor) Extract(ctx context.Context, content []byte) (ExtractedText, error) {
+	return fe.result, fe.err
+}
+
+func TestAttachmentIndexerAdd(t *testing.T) {
+	t.Run("extracts text out-of-process when Extractor is set", func(t *testing.T) {
+		indexer := &recordingBulkIndexer{}
+		extractor := &fakeTextExtractor{result: ExtractedText{
+			Content:     "hello world",
+			ContentType: "text/plain",
+			Language:    "en",
+		}}
+
+		ai, err := NewAttachmentIndexer(AttachmentIndexerConfig{Indexer: indexer, Extractor: extractor})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := ai.Add(context.Background(), "1", []byte("binary content"), nil, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(indexer.items) != 1 {
+			t.Fatalf("expected 1 item, got %d", len(indexer.items))
+		}
+
+		item := indexer.items[0]
+		if item.Pipeline != "" {
+			t.Fatalf("expected no ingest pipeline, got %q", item.Pipeline)
+		}
+
+		var doc map[string]interface{}
+		if err := json.NewDecoder(item.Body).Decode(&doc); err != nil {
+			t.Fatalf("cannot decode item body: %s", err)
+		}
+		if doc["content"] != "hello world" {
+			t.Fatalf("expected content %q, got %v", "hello world", doc["content"])
+		}
+		if doc["content_type"] != "text/plain" {
+			t.Fatalf("expected content_type %q, got %v", "text/plain", doc["content_type"])
+		}
+		if doc["language"] != "en" {
+			t.Fatalf("expected language %q, got %v", "en", doc["language"])
+		}
+	})
+
+	t.Run("base64-encodes content and routes through the ingest pipeline when no Extractor is set", func(t *testing.T) {
+		indexer := &recordingBulkIndexer{}
+
+		ai, err := NewAttachmentIndexer(AttachmentIndexerConfig{Indexer: indexer, Field: "data"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := ai.Add(context.Background(), "1", []byte("binary content"), nil, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(indexer.items) != 1 {
+			t.Fatalf("expected 1 item, got %d", len(indexer.items))
+		}
+
+		item := indexer.items[0]
+		if item.Pipeline != "attachment" {
+			t.Fatalf("expected pipeline %q, got %q", "attachment", item.Pipeline)
+		}
+
+		var doc map[string]interface{}
+		if err := json.NewDecoder(item.Body).Decode(&doc); err != nil {
+			t.Fatalf("cannot decode item body: %s", err)
+		}
+		if doc["data"] != base64.StdEncoding.EncodeToString([]byte("binary content")) {
+			t.Fatalf("expected base64-encoded content, got %v", doc["data"])
+		}
+	})
+
+	t.Run("reports extraction failures via OnExtractionFailure and does not index", func(t *testing.T) {
+		indexer := &recordingBulkIndexer{}
+		extractor := &fakeTextExtractor{err: errors.New("tika is down")}
+
+		var gotDocumentID string
+		var gotErr error
+
+		ai, err := NewAttachmentIndexer(AttachmentIndexerConfig{
+			Indexer:   indexer,
+			Extractor: extractor,
+			OnExtractionFailure: func(ctx context.Context, documentID string, err error) {
+				gotDocumentID = documentID
+				gotErr = err
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := ai.Add(context.Background(), "1", []byte("binary content"), nil, nil, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if len(indexer.items) != 0 {
+			t.Fatalf("expected no items to be indexed, got %d", len(indexer.items))
+		}
+		if gotDocumentID != "1" {
+			t.Fatalf("expected document ID %q, got %q", "1", gotDocumentID)
+		}
+		if gotErr == nil {
+			t.Fatal("expected a non-nil error")
+		}
+	})
+}
+
+func TestAttachmentIndexerPutPipeline(t *testing.T) {
+	ai, err := NewAttachmentIndexer(AttachmentIndexerConfig{Indexer: &recordingBulkIndexer{}, Field: "data"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var gotID string
+	var gotBody map[string]interface{}
+
+	ai.putPipeline = func(id string, body io.Reader) (*esapi.Response, error) {
+		gotID = id
+		if err := json.NewDecoder(body).Decode(&gotBody); err != nil {
+			t.Fatalf("cannot decode pipeline body: %s", err)
+		}
+		return &esapi.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	if err := ai.PutPipeline(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if gotID != "attachment" {
+		t.Fatalf("expected pipeline id %q, got %q", "attachment", gotID)
+	}
+
+	processors, ok := gotBody["processors"].([]interface{})
+	if !ok || len(processors) != 1 {
+		t.Fatalf("expected a single processor, got %v", gotBody["processors"])
+	}
+
+	attachment, ok := processors[0].(map[string]interface{})["attachment"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected an attachment processor, got %v", processors[0])
+	}
+	if attachment["field"] != "data" {
+		t.Fatalf("expected field %q, got %v", "data", attachment["field"])
+	}
+	if attachment["target_field"] != "attachment" {
+		t.Fatalf("expected target_field %q, got %v", "attachment", attachment["target_field"])
+	}
+}
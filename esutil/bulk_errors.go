@@ -0,0 +1,118 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import "fmt"
+
+// esErrorCause is the wire representation of a bulk item error, as returned
+// under "error" (or nested under "caused_by") in the _bulk response.
+//
+type esErrorCause struct {
+	Type     string        `json:"type"`
+	Reason   string        `json:"reason"`
+	Index    string        `json:"index"`
+	Shard    string        `json:"shard"`
+	CausedBy *esErrorCause `json:"caused_by"`
+}
+
+// BulkItemError is the base type for all errors returned for a single bulk
+// item. Use errors.As to test for one of the more specific types below.
+//
+type BulkItemError struct {
+	Type       string
+	Reason     string
+	Index      string
+	Shard      string
+	DocumentID string
+	CausedBy   error
+}
+
+// Error implements the error interface.
+//
+func (e *BulkItemError) Error() string {
+	if e.DocumentID != "" {
+		return fmt.Sprintf("esutil: %s: %s: %s", e.DocumentID, e.Type, e.Reason)
+	}
+	return fmt.Sprintf("esutil: %s: %s", e.Type, e.Reason)
+}
+
+// Unwrap allows errors.Is/errors.As to traverse the "caused_by" chain.
+//
+func (e *BulkItemError) Unwrap() error {
+	return e.CausedBy
+}
+
+// VersionConflictError is returned for a 409 Conflict bulk item, typically
+// during reindex/CDC workloads racing concurrent writers.
+//
+type VersionConflictError struct{ *BulkItemError }
+
+// MappingError is returned when a document does not conform to the index mapping.
+//
+type MappingError struct{ *BulkItemError }
+
+// MapperParsingError is returned when a field value cannot be parsed according
+// to its mapped type.
+//
+type MapperParsingError struct{ *BulkItemError }
+
+// RejectedExecutionError is returned for a 429 Too Many Requests bulk item,
+// i.e. the node's bulk thread pool queue is full.
+//
+type RejectedExecutionError struct{ *BulkItemError }
+
+// IndexNotFoundError is returned when the target index does not exist and
+// auto-creation is disabled.
+//
+type IndexNotFoundError struct{ *BulkItemError }
+
+// newBulkItemError builds the typed error hierarchy for a single failed bulk
+// item, selecting the most specific type based on the response status and
+// the Elasticsearch error "type" field.
+//
+func newBulkItemError(status int, cause *esErrorCause, documentID string) error {
+	if cause == nil {
+		cause = &esErrorCause{}
+	}
+
+	base := &BulkItemError{
+		Type:       cause.Type,
+		Reason:     cause.Reason,
+		Index:      cause.Index,
+		Shard:      cause.Shard,
+		DocumentID: documentID,
+	}
+	if cause.CausedBy != nil {
+		base.CausedBy = newBulkItemError(0, cause.CausedBy, documentID)
+	}
+
+	switch {
+	case status == 409:
+		return &VersionConflictError{base}
+	case status == 429 || cause.Type == "es_rejected_execution_exception":
+		return &RejectedExecutionError{base}
+	case cause.Type == "mapper_parsing_exception":
+		return &MapperParsingError{base}
+	case cause.Type == "mapping_exception" || cause.Type == "strict_dynamic_mapping_exception":
+		return &MappingError{base}
+	case cause.Type == "index_not_found_exception":
+		return &IndexNotFoundError{base}
+	default:
+		return base
+	}
+}
@@ -0,0 +1,147 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewBulkItemError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		cause  *esErrorCause
+		target interface{}
+	}{
+		{
+			name:   "409 is a VersionConflictError",
+			status: 409,
+			cause:  &esErrorCause{Type: "version_conflict_engine_exception", Reason: "conflict"},
+			target: &VersionConflictError{},
+		},
+		{
+			name:   "429 is a RejectedExecutionError",
+			status: 429,
+			cause:  &esErrorCause{Type: "some_other_type", Reason: "queue full"},
+			target: &RejectedExecutionError{},
+		},
+		{
+			name:   "es_rejected_execution_exception is a RejectedExecutionError regardless of status",
+			status: 500,
+			cause:  &esErrorCause{Type: "es_rejected_execution_exception", Reason: "queue full"},
+			target: &RejectedExecutionError{},
+		},
+		{
+			name:   "mapper_parsing_exception is a MapperParsingError",
+			status: 400,
+			cause:  &esErrorCause{Type: "mapper_parsing_exception", Reason: "failed to parse field"},
+			target: &MapperParsingError{},
+		},
+		{
+			name:   "mapping_exception is a MappingError",
+			status: 400,
+			cause:  &esErrorCause{Type: "mapping_exception", Reason: "mismatched mapping"},
+			target: &MappingError{},
+		},
+		{
+			name:   "index_not_found_exception is an IndexNotFoundError",
+			status: 404,
+			cause:  &esErrorCause{Type: "index_not_found_exception", Reason: "no such index"},
+			target: &IndexNotFoundError{},
+		},
+		{
+			name:   "unrecognized types fall back to BulkItemError",
+			status: 400,
+			cause:  &esErrorCause{Type: "illegal_argument_exception", Reason: "bad request"},
+			target: &BulkItemError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newBulkItemError(tt.status, tt.cause, "doc-1")
+
+			switch target := tt.target.(type) {
+			case *VersionConflictError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *VersionConflictError, got %T", err)
+				}
+			case *RejectedExecutionError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *RejectedExecutionError, got %T", err)
+				}
+			case *MapperParsingError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *MapperParsingError, got %T", err)
+				}
+			case *MappingError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *MappingError, got %T", err)
+				}
+			case *IndexNotFoundError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *IndexNotFoundError, got %T", err)
+				}
+			case *BulkItemError:
+				if !errors.As(err, &target) {
+					t.Fatalf("expected a *BulkItemError, got %T", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBulkItemErrorCausedByChain(t *testing.T) {
+	cause := &esErrorCause{
+		Type:   "mapper_parsing_exception",
+		Reason: "failed to parse field [foo]",
+		CausedBy: &esErrorCause{
+			Type:   "number_format_exception",
+			Reason: "for input string: \"bar\"",
+		},
+	}
+
+	err := newBulkItemError(400, cause, "doc-1")
+
+	var mapperErr *MapperParsingError
+	if !errors.As(err, &mapperErr) {
+		t.Fatalf("expected a *MapperParsingError, got %T", err)
+	}
+
+	causedBy := errors.Unwrap(mapperErr)
+	if causedBy == nil {
+		t.Fatal("expected Unwrap to return the caused_by error")
+	}
+
+	var causedByErr *BulkItemError
+	if !errors.As(causedBy, &causedByErr) {
+		t.Fatalf("expected the caused_by error to be a *BulkItemError, got %T", causedBy)
+	}
+	if causedByErr.Type != "number_format_exception" {
+		t.Fatalf("expected caused_by type %q, got %q", "number_format_exception", causedByErr.Type)
+	}
+}
+
+func TestBulkItemErrorMessage(t *testing.T) {
+	err := newBulkItemError(409, &esErrorCause{Type: "version_conflict_engine_exception", Reason: "conflict"}, "doc-42")
+
+	if got := err.Error(); got == "" {
+		t.Fatal("expected a non-empty error message")
+	}
+}
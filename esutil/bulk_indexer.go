@@ -0,0 +1,476 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package esutil provides higher-level helpers built on top of the esapi package.
+package esutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch"
+	"github.com/elastic/go-elasticsearch/esapi"
+)
+
+// BulkIndexer represents a utility for concurrent and efficient indexing of documents into Elasticsearch.
+//
+type BulkIndexer interface {
+	// Add adds an item to the indexer. It returns an error when the item cannot be added.
+	// Use the OnSuccess and OnFailure callbacks to get the operation result for the item.
+	//
+	// You must call the Close() method after you're done adding items.
+	//
+	// It is safe for concurrent use.
+	Add(context.Context, BulkIndexerItem) error
+
+	// Close waits until all added items are flushed and closes the indexer.
+	Close(context.Context) error
+
+	// Stats returns indexer statistics.
+	Stats() BulkIndexerStats
+}
+
+// BulkIndexerConfig represents configuration of BulkIndexer.
+//
+type BulkIndexerConfig struct {
+	NumWorkers    int           // The number of workers. Defaults to 4.
+	FlushBytes    int           // The flush threshold in bytes. Defaults to 5MB.
+	FlushInterval time.Duration // The flush threshold as duration. Defaults to 30sec.
+
+	Client *elasticsearch.Client // The Elasticsearch client.
+	Index  string                // The default index for items which don't specify one.
+
+	// RetryBackoff is called to get the sleep duration before retrying a bulk
+	// request that failed with a 429 (Too Many Requests) or a 5xx status code.
+	// It defaults to a jittered exponential backoff.
+	RetryBackoff func(attempt int) time.Duration
+
+	// MaxRetries is the maximum number of retries for a single batch. Defaults to 3.
+	MaxRetries int
+
+	// BackoffThreshold is the number of bulk errors (including 429s) within
+	// BackoffWindow after which new flushes are paused. Defaults to 5.
+	BackoffThreshold int
+	// BackoffWindow is the sliding window over which errors are counted. Defaults to 30sec.
+	BackoffWindow time.Duration
+	// ClusterBackoff determines how long flushes are paused for once BackoffThreshold
+	// is reached. Defaults to a jittered ExponentialBackoff.
+	ClusterBackoff Backoff
+	// ClusterProbe is called on a pause to check whether the cluster has recovered.
+	// Defaults to a request against the root endpoint (GET /).
+	ClusterProbe func() error
+
+	OnError      func(context.Context, error)          // Called for errors which can not be part of a response.
+	OnFlushStart func(context.Context) context.Context // Called when a flush starts.
+	OnFlushEnd   func(context.Context)                 // Called when a flush finishes.
+}
+
+// BulkIndexerStats represents the indexer statistics.
+//
+type BulkIndexerStats struct {
+	NumAdded    uint64
+	NumFlushed  uint64
+	NumFailed   uint64
+	NumRetried  uint64
+	NumRequests uint64
+	Duration    time.Duration
+
+	// PausedDuration is the cumulative time flushes were paused due to cluster backoff.
+	PausedDuration time.Duration
+}
+
+// BulkIndexerItem represents a single item to be indexed.
+//
+type BulkIndexerItem struct {
+	Index      string
+	Action     string // "index", "create", "delete", "update"
+	DocumentID string
+	Pipeline   string // The ingest pipeline to route the item through, if any.
+	Body       io.Reader
+
+	OnSuccess func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem)
+	OnFailure func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem, err error)
+}
+
+// BulkIndexerResponseItem represents the Elasticsearch response for a single bulk item.
+//
+type BulkIndexerResponseItem struct {
+	Index      string `json:"_index"`
+	DocumentID string `json:"_id"`
+	Version    int64  `json:"_version"`
+	Result     string `json:"result"`
+	Status     int    `json:"status"`
+
+	Error *esErrorCause `json:"error"`
+}
+
+type bulkIndexerResponse struct {
+	Errors bool                                 `json:"errors"`
+	Items  []map[string]BulkIndexerResponseItem `json:"items"`
+}
+
+type bulkIndexer struct {
+	config BulkIndexerConfig
+
+	queue chan BulkIndexerItem
+	wg    sync.WaitGroup
+	done  chan struct{}
+
+	stats    *bulkIndexerStats
+	throttle *clusterThrottle
+
+	// doBulk performs the actual bulk request. It's a field, rather than a
+	// direct call to config.Client.Bulk, so tests can substitute a fake transport.
+	doBulk func(body io.Reader) (*esapi.Response, error)
+}
+
+type bulkIndexerStats struct {
+	numAdded       uint64
+	numFlushed     uint64
+	numFailed      uint64
+	numRetried     uint64
+	numRequests    uint64
+	duration       int64 // nanoseconds, accessed atomically
+	pausedDuration int64 // nanoseconds, accessed atomically
+}
+
+// NewBulkIndexer creates a new bulk indexer.
+//
+func NewBulkIndexer(cfg BulkIndexerConfig) (BulkIndexer, error) {
+	if cfg.Client == nil {
+		cfg.Client, _ = elasticsearch.NewDefaultClient()
+	}
+	if cfg.NumWorkers == 0 {
+		cfg.NumWorkers = 4
+	}
+	if cfg.FlushBytes == 0 {
+		cfg.FlushBytes = 5e+6
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = 30 * time.Second
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff == nil {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.ClusterProbe == nil {
+		client := cfg.Client
+		cfg.ClusterProbe = func() error {
+			res, err := client.Info()
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.IsError() {
+				return fmt.Errorf("esutil: cluster probe failed: %s", res.String())
+			}
+			return nil
+		}
+	}
+
+	bi := bulkIndexer{
+		config:   cfg,
+		queue:    make(chan BulkIndexerItem, cfg.NumWorkers),
+		done:     make(chan struct{}),
+		stats:    &bulkIndexerStats{},
+		throttle: newClusterThrottle(cfg.BackoffThreshold, cfg.BackoffWindow, cfg.ClusterBackoff, cfg.ClusterProbe, func(err error) {
+			if cfg.OnError != nil {
+				cfg.OnError(context.Background(), err)
+			}
+		}),
+		doBulk: func(body io.Reader) (*esapi.Response, error) {
+			return cfg.Client.Bulk(body)
+		},
+	}
+
+	for i := 0; i < cfg.NumWorkers; i++ {
+		bi.wg.Add(1)
+		go bi.worker(i)
+	}
+
+	return &bi, nil
+}
+
+// defaultRetryBackoff returns a jittered exponential backoff duration for the given attempt.
+//
+func defaultRetryBackoff(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
+	jitter := time.Duration(rand.Int63n(int64(base)))
+	return base + jitter
+}
+
+// Add adds an item to the indexer, blocking when the internal queue is full.
+//
+func (bi *bulkIndexer) Add(ctx context.Context, item BulkIndexerItem) error {
+	if item.Index == "" {
+		item.Index = bi.config.Index
+	}
+	if item.Action == "" {
+		item.Action = "index"
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case bi.queue <- item:
+		atomic.AddUint64(&bi.stats.numAdded, 1)
+		return nil
+	}
+}
+
+// Close drains the queue and waits for in-flight flushes to complete.
+//
+func (bi *bulkIndexer) Close(ctx context.Context) error {
+	close(bi.queue)
+
+	done := make(chan struct{})
+	go func() { bi.wg.Wait(); close(done) }()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// Stats returns a snapshot of the indexer statistics.
+//
+func (bi *bulkIndexer) Stats() BulkIndexerStats {
+	return BulkIndexerStats{
+		NumAdded:       atomic.LoadUint64(&bi.stats.numAdded),
+		NumFlushed:     atomic.LoadUint64(&bi.stats.numFlushed),
+		NumFailed:      atomic.LoadUint64(&bi.stats.numFailed),
+		NumRetried:     atomic.LoadUint64(&bi.stats.numRetried),
+		NumRequests:    atomic.LoadUint64(&bi.stats.numRequests),
+		Duration:       time.Duration(atomic.LoadInt64(&bi.stats.duration)),
+		PausedDuration: time.Duration(atomic.LoadInt64(&bi.stats.pausedDuration)),
+	}
+}
+
+// worker accumulates items into batches and flushes them on size or time thresholds.
+//
+func (bi *bulkIndexer) worker(id int) {
+	defer bi.wg.Done()
+
+	var (
+		buf   bytes.Buffer
+		items []BulkIndexerItem
+	)
+
+	timer := time.NewTimer(bi.config.FlushInterval)
+	defer timer.Stop()
+
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		bi.flush(&buf, items)
+		buf.Reset()
+		items = items[:0]
+	}
+
+	for {
+		select {
+		case item, ok := <-bi.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			if err := bi.writeItem(&buf, item); err != nil {
+				atomic.AddUint64(&bi.stats.numFailed, 1)
+				if item.OnFailure != nil {
+					item.OnFailure(context.Background(), item, BulkIndexerResponseItem{}, err)
+				}
+				continue
+			}
+			items = append(items, item)
+
+			if buf.Len() >= bi.config.FlushBytes {
+				flush()
+				timer.Reset(bi.config.FlushInterval)
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(bi.config.FlushInterval)
+		}
+	}
+}
+
+// writeItem encodes the item's action/meta line and body into buf, rolling
+// buf back to its prior length on failure, so that a failing Body never
+// leaves a partial, item-less line behind to corrupt the next flush.
+//
+func (bi *bulkIndexer) writeItem(buf *bytes.Buffer, item BulkIndexerItem) error {
+	mark := buf.Len()
+
+	meta := map[string]interface{}{"_index": item.Index}
+	if item.DocumentID != "" {
+		meta["_id"] = item.DocumentID
+	}
+	if item.Pipeline != "" {
+		meta["pipeline"] = item.Pipeline
+	}
+
+	metaLine, err := json.Marshal(map[string]interface{}{item.Action: meta})
+	if err != nil {
+		return fmt.Errorf("esutil: cannot encode bulk meta line: %s", err)
+	}
+
+	buf.Write(metaLine)
+	buf.WriteRune('\n')
+
+	if item.Body != nil {
+		if _, err := io.Copy(buf, item.Body); err != nil {
+			buf.Truncate(mark)
+			return fmt.Errorf("esutil: cannot copy bulk item body: %s", err)
+		}
+		buf.WriteRune('\n')
+	}
+
+	return nil
+}
+
+// flush sends the accumulated batch, retrying on 429/5xx, and dispatches callbacks.
+//
+func (bi *bulkIndexer) flush(buf *bytes.Buffer, items []BulkIndexerItem) {
+	ctx := context.Background()
+	if bi.config.OnFlushStart != nil {
+		ctx = bi.config.OnFlushStart(ctx)
+	}
+	if bi.config.OnFlushEnd != nil {
+		defer bi.config.OnFlushEnd(ctx)
+	}
+
+	start := time.Now()
+	defer func() { atomic.AddInt64(&bi.stats.duration, int64(time.Since(start))) }()
+
+	body := buf.Bytes()
+
+	var (
+		res *esapi.Response
+		err error
+	)
+
+	for attempt := 0; attempt <= bi.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			atomic.AddUint64(&bi.stats.numRetried, 1)
+			time.Sleep(bi.config.RetryBackoff(attempt))
+		}
+
+		pauseStart := time.Now()
+		bi.throttle.Wait()
+		atomic.AddInt64(&bi.stats.pausedDuration, int64(time.Since(pauseStart)))
+
+		atomic.AddUint64(&bi.stats.numRequests, 1)
+		res, err = bi.doBulk(bytes.NewReader(body))
+		if err != nil {
+			if bi.config.OnError != nil {
+				bi.config.OnError(ctx, err)
+			}
+			bi.throttle.RecordError(time.Now())
+			continue
+		}
+
+		if res.StatusCode == 429 || res.StatusCode >= 500 {
+			res.Body.Close()
+			bi.throttle.RecordError(time.Now())
+			continue
+		}
+
+		break
+	}
+
+	atomic.AddUint64(&bi.stats.numFlushed, uint64(len(items)))
+
+	if err != nil || res == nil {
+		for _, item := range items {
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, BulkIndexerResponseItem{}, err)
+			}
+		}
+		atomic.AddUint64(&bi.stats.numFailed, uint64(len(items)))
+		return
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		for _, item := range items {
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, BulkIndexerResponseItem{}, fmt.Errorf("esutil: bulk request failed: %s", res.String()))
+			}
+		}
+		atomic.AddUint64(&bi.stats.numFailed, uint64(len(items)))
+		return
+	}
+
+	var blk bulkIndexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&blk); err != nil {
+		if bi.config.OnError != nil {
+			bi.config.OnError(ctx, fmt.Errorf("esutil: cannot decode bulk response: %s", err))
+		}
+		return
+	}
+
+	var rejected bool
+
+	for i, item := range items {
+		if i >= len(blk.Items) {
+			continue
+		}
+
+		var result BulkIndexerResponseItem
+		for _, v := range blk.Items[i] {
+			result = v
+		}
+
+		if result.Status > 201 {
+			atomic.AddUint64(&bi.stats.numFailed, 1)
+
+			itemErr := newBulkItemError(result.Status, result.Error, item.DocumentID)
+			var rejectedErr *RejectedExecutionError
+			if errors.As(itemErr, &rejectedErr) {
+				rejected = true
+				bi.throttle.RecordError(time.Now())
+			}
+
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, result, itemErr)
+			}
+			continue
+		}
+
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, item, result)
+		}
+	}
+
+	if !rejected {
+		bi.throttle.RecordSuccess()
+	}
+}
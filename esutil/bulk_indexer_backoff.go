@@ -0,0 +1,212 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrClusterProbeGaveUp is reported to onStop (and, for a BulkIndexer, its
+// OnError callback) when the configured Backoff reports that no further
+// probe attempts should be made. The gate stays closed from that point on,
+// so every subsequent flush blocks in Wait; callers relying on MaxRetries or
+// StopBackoff should treat this as fatal and shut the indexer down.
+var ErrClusterProbeGaveUp = errors.New("esutil: cluster probe backoff exhausted, indexer remains paused")
+
+// Backoff computes the sleep duration to wait before retrying the next operation,
+// or reports that no further retries should be attempted.
+//
+type Backoff interface {
+	// Next returns the duration to sleep for the given attempt (0-based) and
+	// whether the caller should retry at all; false means stop retrying.
+	Next(attempt int) (time.Duration, bool)
+}
+
+// ConstantBackoff waits for a fixed duration between retries, up to MaxRetries.
+//
+type ConstantBackoff struct {
+	Interval   time.Duration
+	MaxRetries int
+}
+
+// Next implements the Backoff interface.
+//
+func (b ConstantBackoff) Next(attempt int) (time.Duration, bool) {
+	if b.MaxRetries > 0 && attempt >= b.MaxRetries {
+		return 0, false
+	}
+	return b.Interval, true
+}
+
+// ExponentialBackoff waits for an exponentially increasing, jittered duration between
+// retries, capped at Max, up to MaxRetries.
+//
+type ExponentialBackoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	MaxRetries int
+}
+
+// Next implements the Backoff interface.
+//
+func (b ExponentialBackoff) Next(attempt int) (time.Duration, bool) {
+	if b.MaxRetries > 0 && attempt >= b.MaxRetries {
+		return 0, false
+	}
+
+	base := b.Base
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+
+	d := time.Duration(float64(base) * math.Pow(2, float64(attempt)))
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(d) + 1))
+	return d/2 + jitter/2, true
+}
+
+// StopBackoff reports that no further retries should be attempted.
+//
+type StopBackoff struct{}
+
+// Next implements the Backoff interface.
+//
+func (b StopBackoff) Next(attempt int) (time.Duration, bool) {
+	return 0, false
+}
+
+// clusterThrottle tracks bulk errors over a sliding window and gates flushes
+// when the cluster appears to be degraded.
+//
+type clusterThrottle struct {
+	threshold int
+	window    time.Duration
+	backoff   Backoff
+	probe     func() error
+	onStop    func(error)
+
+	mu     sync.Mutex
+	errors []time.Time
+	paused bool
+	gate   chan struct{}
+}
+
+func newClusterThrottle(threshold int, window time.Duration, backoff Backoff, probe func() error, onStop func(error)) *clusterThrottle {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if window <= 0 {
+		window = 30 * time.Second
+	}
+	if backoff == nil {
+		backoff = ExponentialBackoff{Base: 500 * time.Millisecond, Max: 30 * time.Second}
+	}
+	if probe == nil {
+		probe = func() error { return nil }
+	}
+	if onStop == nil {
+		onStop = func(error) {}
+	}
+
+	ct := clusterThrottle{threshold: threshold, window: window, backoff: backoff, probe: probe, onStop: onStop}
+	ct.gate = make(chan struct{})
+	close(ct.gate) // open by default
+
+	return &ct
+}
+
+// RecordError registers a bulk failure (including a 429) and pauses the gate
+// once the threshold is reached within the sliding window.
+//
+func (ct *clusterThrottle) RecordError(now time.Time) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.errors = append(ct.errors, now)
+
+	cutoff := now.Add(-ct.window)
+	i := 0
+	for ; i < len(ct.errors); i++ {
+		if ct.errors[i].After(cutoff) {
+			break
+		}
+	}
+	ct.errors = ct.errors[i:]
+
+	if len(ct.errors) >= ct.threshold && !ct.paused {
+		ct.pause()
+		go ct.probeUntilResumed()
+	}
+}
+
+// RecordSuccess clears the error window.
+//
+func (ct *clusterThrottle) RecordSuccess() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.errors = ct.errors[:0]
+}
+
+// Wait blocks until the gate is open, i.e. the cluster is no longer considered degraded.
+//
+func (ct *clusterThrottle) Wait() {
+	ct.mu.Lock()
+	gate := ct.gate
+	ct.mu.Unlock()
+
+	<-gate
+}
+
+// pause closes the gate; callers must hold ct.mu.
+//
+func (ct *clusterThrottle) pause() {
+	ct.paused = true
+	ct.gate = make(chan struct{})
+}
+
+// probeUntilResumed sends probe requests, honoring backoff, until one succeeds
+// and reopens the gate, or the backoff reports that no further attempts
+// should be made, in which case the gate stays closed and onStop is called.
+//
+func (ct *clusterThrottle) probeUntilResumed() {
+	for attempt := 0; ; attempt++ {
+		d, ok := ct.backoff.Next(attempt)
+		if !ok {
+			ct.onStop(ErrClusterProbeGaveUp)
+			return
+		}
+		time.Sleep(d)
+
+		if ct.probe() == nil {
+			ct.mu.Lock()
+			ct.paused = false
+			ct.errors = ct.errors[:0]
+			close(ct.gate)
+			ct.mu.Unlock()
+			return
+		}
+	}
+}
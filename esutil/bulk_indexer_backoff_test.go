@@ -0,0 +1,144 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClusterThrottle(t *testing.T) {
+	t.Run("stays open below the error threshold", func(t *testing.T) {
+		ct := newClusterThrottle(3, time.Minute, ConstantBackoff{Interval: time.Millisecond}, nil, nil)
+
+		ct.RecordError(time.Now())
+		ct.RecordError(time.Now())
+
+		select {
+		case <-ct.gate:
+		default:
+			t.Fatal("expected gate to remain open below the threshold")
+		}
+	})
+
+	t.Run("pauses once the threshold is reached and resumes after a successful probe", func(t *testing.T) {
+		var probeCalls int32
+
+		probe := func() error {
+			if atomic.AddInt32(&probeCalls, 1) < 3 {
+				return errors.New("cluster still degraded")
+			}
+			return nil
+		}
+
+		ct := newClusterThrottle(2, time.Minute, ConstantBackoff{Interval: time.Millisecond}, probe, nil)
+
+		now := time.Now()
+		ct.RecordError(now)
+		ct.RecordError(now)
+
+		select {
+		case <-ct.gate:
+			t.Fatal("expected gate to be closed once the threshold was reached")
+		default:
+		}
+
+		done := make(chan struct{})
+		go func() {
+			ct.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected Wait to unblock once the probe succeeded")
+		}
+
+		if calls := atomic.LoadInt32(&probeCalls); calls < 3 {
+			t.Fatalf("expected at least 3 probe calls, got %d", calls)
+		}
+	})
+
+	t.Run("sliding window drops errors older than the window", func(t *testing.T) {
+		ct := newClusterThrottle(2, time.Millisecond, ConstantBackoff{Interval: time.Millisecond}, nil, nil)
+
+		ct.RecordError(time.Now().Add(-time.Hour))
+		ct.RecordError(time.Now())
+
+		select {
+		case <-ct.gate:
+		default:
+			t.Fatal("expected gate to remain open: the first error fell outside the window")
+		}
+	})
+
+	t.Run("RecordSuccess clears the error window", func(t *testing.T) {
+		ct := newClusterThrottle(2, time.Minute, ConstantBackoff{Interval: time.Millisecond}, nil, nil)
+
+		ct.RecordError(time.Now())
+		ct.RecordSuccess()
+		ct.RecordError(time.Now())
+
+		select {
+		case <-ct.gate:
+		default:
+			t.Fatal("expected gate to remain open after RecordSuccess reset the window")
+		}
+	})
+
+	t.Run("stops probing and stays paused once the backoff reports it should give up", func(t *testing.T) {
+		var probeCalls int32
+		stopped := make(chan error, 1)
+
+		probe := func() error {
+			atomic.AddInt32(&probeCalls, 1)
+			return errors.New("cluster still degraded")
+		}
+		onStop := func(err error) {
+			stopped <- err
+		}
+
+		ct := newClusterThrottle(2, time.Minute, ConstantBackoff{Interval: time.Millisecond, MaxRetries: 2}, probe, onStop)
+
+		now := time.Now()
+		ct.RecordError(now)
+		ct.RecordError(now)
+
+		select {
+		case stopErr := <-stopped:
+			if !errors.Is(stopErr, ErrClusterProbeGaveUp) {
+				t.Fatalf("expected ErrClusterProbeGaveUp, got %v", stopErr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected onStop to be called once the backoff gave up")
+		}
+
+		select {
+		case <-ct.gate:
+			t.Fatal("expected gate to remain closed once probing gave up")
+		default:
+		}
+
+		if calls := atomic.LoadInt32(&probeCalls); calls == 0 {
+			t.Fatal("expected at least one probe call before giving up")
+		}
+	})
+}
@@ -0,0 +1,259 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/esapi"
+)
+
+// newTestBulkIndexer creates a *bulkIndexer via NewBulkIndexer, then swaps in
+// doBulk so tests never perform a real HTTP request.
+func newTestBulkIndexer(t *testing.T, cfg BulkIndexerConfig, doBulk func(body io.Reader) (*esapi.Response, error)) *bulkIndexer {
+	t.Helper()
+
+	indexer, err := NewBulkIndexer(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	bi := indexer.(*bulkIndexer)
+	bi.doBulk = doBulk
+	return bi
+}
+
+func bulkResponseBody(items ...string) io.ReadCloser {
+	return ioutil.NopCloser(strings.NewReader(`{"errors":false,"items":[` + strings.Join(items, ",") + `]}`))
+}
+
+func TestBulkIndexerBatching(t *testing.T) {
+	t.Run("flushes once FlushBytes is exceeded", func(t *testing.T) {
+		var numRequests int32
+
+		bi := newTestBulkIndexer(t, BulkIndexerConfig{
+			NumWorkers:    1,
+			FlushBytes:    1,
+			FlushInterval: time.Hour,
+			MaxRetries:    0,
+		}, func(body io.Reader) (*esapi.Response, error) {
+			atomic.AddInt32(&numRequests, 1)
+			return &esapi.Response{StatusCode: 200, Body: bulkResponseBody(`{"index":{"status":201}}`)}, nil
+		})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for i := 0; i < 2; i++ {
+			bi.Add(context.Background(), BulkIndexerItem{
+				DocumentID: "doc",
+				Body:       strings.NewReader(`{"field":"value"}`),
+				OnSuccess:  func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem) { wg.Done() },
+			})
+		}
+
+		waitOrTimeout(t, &wg, time.Second, "expected both items to flush on size")
+
+		if err := bi.Close(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if atomic.LoadInt32(&numRequests) < 2 {
+			t.Fatalf("expected at least 2 requests, got %d", numRequests)
+		}
+	})
+
+	t.Run("flushes on FlushInterval even under the byte threshold", func(t *testing.T) {
+		done := make(chan struct{})
+
+		bi := newTestBulkIndexer(t, BulkIndexerConfig{
+			NumWorkers:    1,
+			FlushBytes:    5e6,
+			FlushInterval: 10 * time.Millisecond,
+			MaxRetries:    0,
+		}, func(body io.Reader) (*esapi.Response, error) {
+			return &esapi.Response{StatusCode: 200, Body: bulkResponseBody(`{"index":{"status":201}}`)}, nil
+		})
+
+		bi.Add(context.Background(), BulkIndexerItem{
+			DocumentID: "doc",
+			Body:       strings.NewReader(`{"field":"value"}`),
+			OnSuccess:  func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem) { close(done) },
+		})
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected the timer to flush the batch")
+		}
+
+		if err := bi.Close(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+func TestBulkIndexerRetry(t *testing.T) {
+	t.Run("retries on 429/5xx up to MaxRetries, then reports failure", func(t *testing.T) {
+		var numRequests int32
+
+		bi := newTestBulkIndexer(t, BulkIndexerConfig{
+			NumWorkers:    1,
+			FlushBytes:    1,
+			FlushInterval: time.Hour,
+			MaxRetries:    2,
+			RetryBackoff:  func(int) time.Duration { return time.Millisecond },
+		}, func(body io.Reader) (*esapi.Response, error) {
+			atomic.AddInt32(&numRequests, 1)
+			return &esapi.Response{StatusCode: 503, Body: bulkResponseBody()}, nil
+		})
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		var gotErr error
+		bi.Add(context.Background(), BulkIndexerItem{
+			DocumentID: "doc",
+			Body:       strings.NewReader(`{"field":"value"}`),
+			OnFailure: func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem, err error) {
+				gotErr = err
+				wg.Done()
+			},
+		})
+
+		waitOrTimeout(t, &wg, time.Second, "expected OnFailure to be called once retries are exhausted")
+
+		if err := bi.Close(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if gotErr == nil {
+			t.Fatal("expected a non-nil error")
+		}
+		if got := int32(3); numRequests != got { // initial attempt + 2 retries
+			t.Fatalf("expected %d requests, got %d", got, numRequests)
+		}
+		if stats := bi.Stats(); stats.NumRetried != 2 {
+			t.Fatalf("expected NumRetried to be 2, got %d", stats.NumRetried)
+		}
+	})
+}
+
+func TestBulkIndexerClose(t *testing.T) {
+	t.Run("drains queued items before returning", func(t *testing.T) {
+		var numFlushed int32
+
+		bi := newTestBulkIndexer(t, BulkIndexerConfig{
+			NumWorkers:    1,
+			FlushBytes:    5e6,
+			FlushInterval: time.Hour,
+		}, func(body io.Reader) (*esapi.Response, error) {
+			atomic.AddInt32(&numFlushed, 1)
+			return &esapi.Response{StatusCode: 200, Body: bulkResponseBody(`{"index":{"status":201}}`)}, nil
+		})
+
+		for i := 0; i < 5; i++ {
+			bi.Add(context.Background(), BulkIndexerItem{
+				DocumentID: "doc",
+				Body:       strings.NewReader(`{"field":"value"}`),
+			})
+		}
+
+		if err := bi.Close(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if atomic.LoadInt32(&numFlushed) != 1 {
+			t.Fatalf("expected the queued items to flush as a single batch on Close, got %d requests", numFlushed)
+		}
+		if stats := bi.Stats(); stats.NumFlushed != 5 {
+			t.Fatalf("expected NumFlushed to be 5, got %d", stats.NumFlushed)
+		}
+	})
+}
+
+// failingReader always errors on Read, simulating a broken streaming Body.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("boom: stream broken") }
+
+func TestBulkIndexerWriteItemFailure(t *testing.T) {
+	t.Run("an item whose Body fails to copy still reaches OnFailure and NumFailed", func(t *testing.T) {
+		var bulkRequestIssued int32
+
+		bi := newTestBulkIndexer(t, BulkIndexerConfig{
+			NumWorkers:    1,
+			FlushBytes:    5e6,
+			FlushInterval: time.Hour,
+		}, func(body io.Reader) (*esapi.Response, error) {
+			atomic.AddInt32(&bulkRequestIssued, 1)
+			return &esapi.Response{StatusCode: 200, Body: bulkResponseBody()}, nil
+		})
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		var gotErr error
+		bi.Add(context.Background(), BulkIndexerItem{
+			DocumentID: "doc",
+			Body:       failingReader{},
+			OnFailure: func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem, err error) {
+				gotErr = err
+				wg.Done()
+			},
+		})
+
+		waitOrTimeout(t, &wg, time.Second, "expected OnFailure to be called for the item whose Body failed to copy")
+
+		if err := bi.Close(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if gotErr == nil {
+			t.Fatal("expected a non-nil error")
+		}
+		if stats := bi.Stats(); stats.NumFailed != 1 {
+			t.Fatalf("expected NumFailed to be 1, got %d", stats.NumFailed)
+		}
+		if atomic.LoadInt32(&bulkRequestIssued) != 0 {
+			t.Fatal("expected no bulk request for a batch containing only a failed-to-encode item")
+		}
+	})
+}
+
+func waitOrTimeout(t *testing.T, wg *sync.WaitGroup, d time.Duration, msg string) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal(msg)
+	}
+}
@@ -0,0 +1,280 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch"
+)
+
+// MultiSearchItem represents a single search to be submitted as part of a _msearch request.
+//
+type MultiSearchItem struct {
+	Index      string
+	Preference string
+	Query      interface{} // Marshaled to JSON; may also be a json.RawMessage.
+}
+
+// MultiSearchResult represents the outcome of a single item submitted via MultiSearcher.Add.
+//
+// Exactly one of Response, Raw or Error is populated: Error when Elasticsearch
+// reported a per-item failure (e.g. a missing shard), Raw when the searcher is
+// configured for zero-copy passthrough, and Response otherwise.
+//
+type MultiSearchResult struct {
+	Response *SearchResponse
+	Raw      json.RawMessage
+	Error    error
+}
+
+// SearchResponse represents a decoded Elasticsearch search response.
+//
+type SearchResponse struct {
+	Took     int  `json:"took"`
+	TimedOut bool `json:"timed_out"`
+	Hits     struct {
+		Total    int               `json:"total"`
+		MaxScore float64           `json:"max_score"`
+		Hits     []SearchResultHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// SearchResultHit represents a single hit within a SearchResponse.
+//
+type SearchResultHit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  float64         `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// MultiSearcherConfig represents configuration of MultiSearcher.
+//
+type MultiSearcherConfig struct {
+	Client *elasticsearch.Client // The Elasticsearch client.
+	Index  string                // The default index for items which don't specify one.
+
+	// MaxBodyBytes is the byte-size threshold at which Run splits the queued
+	// items across multiple _msearch requests. Defaults to 5MB.
+	MaxBodyBytes int
+
+	// RawResults, when true, makes Run populate MultiSearchResult.Raw instead
+	// of decoding into MultiSearchResult.Response.
+	RawResults bool
+}
+
+// MultiSearcher accumulates MultiSearchItem values and submits them as one or
+// more _msearch requests, mirroring the NDJSON header/body framing used by
+// the Bulk API.
+//
+type MultiSearcher struct {
+	config MultiSearcherConfig
+	items  []MultiSearchItem
+}
+
+// NewMultiSearcher creates a new MultiSearcher.
+//
+func NewMultiSearcher(cfg MultiSearcherConfig) (*MultiSearcher, error) {
+	if cfg.Client == nil {
+		cfg.Client, _ = elasticsearch.NewDefaultClient()
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 5e+6
+	}
+
+	return &MultiSearcher{config: cfg}, nil
+}
+
+// Add queues item for submission and returns its position, which corresponds
+// to the index of its result in the slice returned by Run.
+//
+func (ms *MultiSearcher) Add(item MultiSearchItem) int {
+	ms.items = append(ms.items, item)
+	return len(ms.items) - 1
+}
+
+// Run submits the queued items across as many _msearch requests as required
+// to stay under MaxBodyBytes, and returns one MultiSearchResult per item, in
+// submission order.
+//
+func (ms *MultiSearcher) Run(ctx context.Context) ([]MultiSearchResult, error) {
+	results := make([]MultiSearchResult, len(ms.items))
+
+	for _, batch := range ms.batches(results) {
+		body, err := ms.encodeBatch(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := ms.config.Client.Msearch(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("esutil: msearch request failed: %s", err)
+		}
+
+		if err := ms.decodeBatch(res.Body, batch, results); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		res.Body.Close()
+	}
+
+	ms.items = nil
+
+	return results, nil
+}
+
+type multiSearchBatch struct {
+	indices []int
+	items   []MultiSearchItem
+}
+
+// batches splits the queued items into groups which stay under MaxBodyBytes.
+// Items that fail to encode are recorded as errors in results and excluded
+// from every batch.
+//
+func (ms *MultiSearcher) batches(results []MultiSearchResult) []multiSearchBatch {
+	var (
+		batches []multiSearchBatch
+		current multiSearchBatch
+		size    int
+	)
+
+	for i, item := range ms.items {
+		line, err := ms.encodeItem(item)
+		if err != nil {
+			results[i] = MultiSearchResult{Error: err}
+			continue
+		}
+
+		if size > 0 && size+len(line) > ms.config.MaxBodyBytes {
+			batches = append(batches, current)
+			current = multiSearchBatch{}
+			size = 0
+		}
+
+		current.indices = append(current.indices, i)
+		current.items = append(current.items, item)
+		size += len(line)
+	}
+
+	if len(current.items) > 0 {
+		batches = append(batches, current)
+	}
+
+	return batches
+}
+
+// encodeItem renders a single item's header and query as NDJSON.
+//
+func (ms *MultiSearcher) encodeItem(item MultiSearchItem) ([]byte, error) {
+	index := item.Index
+	if index == "" {
+		index = ms.config.Index
+	}
+
+	header := map[string]interface{}{}
+	if index != "" {
+		header["index"] = index
+	}
+	if item.Preference != "" {
+		header["preference"] = item.Preference
+	}
+
+	headerLine, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("esutil: cannot encode msearch header: %s", err)
+	}
+
+	queryLine, err := json.Marshal(item.Query)
+	if err != nil {
+		return nil, fmt.Errorf("esutil: cannot encode msearch query: %s", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(headerLine)
+	buf.WriteRune('\n')
+	buf.Write(queryLine)
+	buf.WriteRune('\n')
+
+	return buf.Bytes(), nil
+}
+
+// encodeBatch renders a batch of items as a single NDJSON body.
+//
+func (ms *MultiSearcher) encodeBatch(batch multiSearchBatch) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, item := range batch.items {
+		line, err := ms.encodeItem(item)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+	}
+
+	return buf.Bytes(), nil
+}
+
+type multiSearchResponse struct {
+	Responses []json.RawMessage `json:"responses"`
+}
+
+// decodeBatch decodes the _msearch response body, placing per-item results
+// into results at the positions recorded in batch.indices.
+//
+func (ms *MultiSearcher) decodeBatch(body io.Reader, batch multiSearchBatch, results []MultiSearchResult) error {
+	var raw multiSearchResponse
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return fmt.Errorf("esutil: cannot decode msearch response: %s", err)
+	}
+
+	for i, resultIndex := range batch.indices {
+		if i >= len(raw.Responses) {
+			results[resultIndex] = MultiSearchResult{Error: fmt.Errorf("esutil: no msearch response for item %d", resultIndex)}
+			continue
+		}
+
+		var perItem struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw.Responses[i], &perItem); err == nil && len(perItem.Error) > 0 {
+			results[resultIndex] = MultiSearchResult{Error: fmt.Errorf("esutil: %s", perItem.Error)}
+			continue
+		}
+
+		if ms.config.RawResults {
+			results[resultIndex] = MultiSearchResult{Raw: raw.Responses[i]}
+			continue
+		}
+
+		var sr SearchResponse
+		if err := json.Unmarshal(raw.Responses[i], &sr); err != nil {
+			results[resultIndex] = MultiSearchResult{Error: fmt.Errorf("esutil: cannot decode search response: %s", err)}
+			continue
+		}
+
+		results[resultIndex] = MultiSearchResult{Response: &sr}
+	}
+
+	return nil
+}
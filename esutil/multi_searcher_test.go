@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMultiSearcherBatches(t *testing.T) {
+	t.Run("splits items once MaxBodyBytes is exceeded", func(t *testing.T) {
+		ms := &MultiSearcher{config: MultiSearcherConfig{MaxBodyBytes: 1}}
+		ms.Add(MultiSearchItem{Index: "a", Query: map[string]interface{}{"query": "match_all"}})
+		ms.Add(MultiSearchItem{Index: "b", Query: map[string]interface{}{"query": "match_all"}})
+
+		results := make([]MultiSearchResult, len(ms.items))
+		batches := ms.batches(results)
+
+		if len(batches) != 2 {
+			t.Fatalf("expected 2 batches, got %d", len(batches))
+		}
+		if len(batches[0].items) != 1 || len(batches[1].items) != 1 {
+			t.Fatalf("expected 1 item per batch, got %d and %d", len(batches[0].items), len(batches[1].items))
+		}
+	})
+
+	t.Run("records an error for items which fail to encode, instead of dropping them", func(t *testing.T) {
+		ms := &MultiSearcher{config: MultiSearcherConfig{MaxBodyBytes: 5e6}}
+		ms.Add(MultiSearchItem{Index: "a", Query: map[string]interface{}{"query": "match_all"}})
+		ms.Add(MultiSearchItem{Index: "b", Query: func() {}}) // funcs are not JSON-marshalable
+
+		results := make([]MultiSearchResult, len(ms.items))
+		batches := ms.batches(results)
+
+		if len(batches) != 1 || len(batches[0].items) != 1 {
+			t.Fatalf("expected the unencodable item to be excluded from the batches, got %+v", batches)
+		}
+
+		if results[1].Error == nil {
+			t.Fatal("expected results[1].Error to be populated for the item that failed to encode")
+		}
+		if results[1].Response != nil || results[1].Raw != nil {
+			t.Fatalf("expected only Error to be populated, got %+v", results[1])
+		}
+	})
+}
+
+func TestMultiSearcherDecodeBatch(t *testing.T) {
+	ms := &MultiSearcher{}
+
+	batch := multiSearchBatch{
+		indices: []int{2, 0},
+		items:   []MultiSearchItem{{Index: "a"}, {Index: "b"}},
+	}
+	results := make([]MultiSearchResult, 3)
+
+	body := strings.NewReader(`{"responses":[{"took":1,"hits":{"total":1,"hits":[]}},{"error":{"type":"index_not_found_exception","reason":"no such index"}}]}`)
+
+	if err := ms.decodeBatch(body, batch, results); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if results[2].Response == nil || results[2].Error != nil {
+		t.Fatalf("expected results[2] to carry a decoded response, got %+v", results[2])
+	}
+	if results[0].Error == nil || results[0].Response != nil {
+		t.Fatalf("expected results[0] to carry a per-item error, got %+v", results[0])
+	}
+	if results[1].Response != nil || results[1].Error != nil {
+		t.Fatalf("expected results[1] to be left untouched, got %+v", results[1])
+	}
+}
@@ -0,0 +1,195 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Encoder marshals a document into its wire representation.
+//
+type Encoder interface {
+	Encode(doc interface{}) ([]byte, error)
+}
+
+// jsonEncoder is the default Encoder, using encoding/json with a fast path for
+// types which implement json.Marshaler (e.g. easyjson-generated MarshalJSON).
+//
+type jsonEncoder struct{}
+
+// Encode implements the Encoder interface.
+//
+func (jsonEncoder) Encode(doc interface{}) ([]byte, error) {
+	if m, ok := doc.(json.Marshaler); ok {
+		return m.MarshalJSON()
+	}
+	return json.Marshal(doc)
+}
+
+// IndexResolver returns the target index name for a given document, e.g. for
+// time-based rollover indices such as "logs-2006.01.02".
+//
+type IndexResolver func(doc interface{}) (string, error)
+
+// TypedBulkIndexer wraps a BulkIndexer and derives the document ID and body
+// from arbitrary Go values, instead of requiring callers to build
+// BulkIndexerItem values by hand.
+//
+type TypedBulkIndexer struct {
+	indexer BulkIndexer
+	encoder Encoder
+
+	// IDFunc is used to derive a document's "_id" when the document has
+	// neither an `es:"id"` struct tag nor an "ID" field.
+	IDFunc func(doc interface{}) string
+
+	mu        sync.RWMutex
+	resolvers map[reflect.Type]IndexResolver
+}
+
+// TypedBulkIndexerConfig represents configuration for a TypedBulkIndexer.
+//
+type TypedBulkIndexerConfig struct {
+	Indexer BulkIndexer // The underlying BulkIndexer. Required.
+	Encoder Encoder     // Defaults to a json.Marshal-based Encoder.
+	IDFunc  func(doc interface{}) string
+}
+
+// NewTypedBulkIndexer creates a new TypedBulkIndexer on top of an existing BulkIndexer.
+//
+func NewTypedBulkIndexer(cfg TypedBulkIndexerConfig) (*TypedBulkIndexer, error) {
+	if cfg.Indexer == nil {
+		return nil, fmt.Errorf("esutil: Indexer must be set")
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = jsonEncoder{}
+	}
+
+	return &TypedBulkIndexer{
+		indexer:   cfg.Indexer,
+		encoder:   cfg.Encoder,
+		IDFunc:    cfg.IDFunc,
+		resolvers: make(map[reflect.Type]IndexResolver),
+	}, nil
+}
+
+// RegisterIndexResolver registers an IndexResolver for the type of sample,
+// e.g. RegisterIndexResolver(LogEntry{}, resolver), where resolver returns a
+// daily index name such as "logs-2006.01.02".
+//
+func (ti *TypedBulkIndexer) RegisterIndexResolver(sample interface{}, resolver IndexResolver) {
+	t := reflect.TypeOf(sample)
+
+	ti.mu.Lock()
+	ti.resolvers[t] = resolver
+	ti.mu.Unlock()
+}
+
+// Add encodes doc, derives its "_id" and target index, and forwards it to the
+// underlying BulkIndexer.
+//
+func (ti *TypedBulkIndexer) Add(ctx context.Context, action string, doc interface{}, onSuccess func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem), onFailure func(ctx context.Context, item BulkIndexerItem, res BulkIndexerResponseItem, err error)) error {
+	body, err := ti.encoder.Encode(doc)
+	if err != nil {
+		return fmt.Errorf("esutil: cannot encode document: %s", err)
+	}
+
+	id, err := ti.documentID(doc)
+	if err != nil {
+		return err
+	}
+
+	index, err := ti.resolveIndex(doc)
+	if err != nil {
+		return err
+	}
+
+	return ti.indexer.Add(ctx, BulkIndexerItem{
+		Index:      index,
+		Action:     action,
+		DocumentID: id,
+		Body:       bytes.NewReader(body),
+		OnSuccess:  onSuccess,
+		OnFailure:  onFailure,
+	})
+}
+
+// Close closes the underlying BulkIndexer.
+//
+func (ti *TypedBulkIndexer) Close(ctx context.Context) error {
+	return ti.indexer.Close(ctx)
+}
+
+// Stats returns the underlying BulkIndexer statistics.
+//
+func (ti *TypedBulkIndexer) Stats() BulkIndexerStats {
+	return ti.indexer.Stats()
+}
+
+// documentID derives a document's "_id": an `es:"id"` tagged field, else a
+// field named "ID", else the configured IDFunc.
+//
+func (ti *TypedBulkIndexer) documentID(doc interface{}) (string, error) {
+	v := reflect.Indirect(reflect.ValueOf(doc))
+
+	if v.Kind() == reflect.Struct {
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Tag.Get("es") == "id" && v.Field(i).CanInterface() {
+				return fmt.Sprintf("%v", v.Field(i).Interface()), nil
+			}
+		}
+
+		if f := v.FieldByName("ID"); f.IsValid() {
+			return fmt.Sprintf("%v", f.Interface()), nil
+		}
+	}
+
+	if ti.IDFunc != nil {
+		return ti.IDFunc(doc), nil
+	}
+
+	return "", fmt.Errorf("esutil: cannot derive document ID for %T: no `es:\"id\"` tag, ID field, or IDFunc", doc)
+}
+
+// resolveIndex looks up a registered IndexResolver for the document's type,
+// indirecting through a pointer the same way documentID does, so a resolver
+// registered for LogEntry{} is also found for *LogEntry.
+//
+func (ti *TypedBulkIndexer) resolveIndex(doc interface{}) (string, error) {
+	t := reflect.TypeOf(doc)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	ti.mu.RLock()
+	resolver, ok := ti.resolvers[t]
+	ti.mu.RUnlock()
+
+	if !ok {
+		return "", nil
+	}
+
+	return resolver(doc)
+}
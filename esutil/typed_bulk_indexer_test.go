@@ -0,0 +1,144 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package esutil
+
+import (
+	"context"
+	"testing"
+)
+
+type testLogEntry struct {
+	ID      string `es:"id"`
+	Message string
+}
+
+type testNoTagDoc struct {
+	ID      int
+	Message string
+}
+
+type testUnexportedIDDoc struct {
+	id      string `es:"id"`
+	Message string
+}
+
+func TestTypedBulkIndexerDocumentID(t *testing.T) {
+	ti := &TypedBulkIndexer{}
+
+	t.Run("uses the `es:\"id\"` tagged field", func(t *testing.T) {
+		id, err := ti.documentID(testLogEntry{ID: "abc", Message: "hello"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if id != "abc" {
+			t.Fatalf("expected id %q, got %q", "abc", id)
+		}
+	})
+
+	t.Run("falls back to a field named ID", func(t *testing.T) {
+		id, err := ti.documentID(testNoTagDoc{ID: 42})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if id != "42" {
+			t.Fatalf("expected id %q, got %q", "42", id)
+		}
+	})
+
+	t.Run("indirects through a pointer", func(t *testing.T) {
+		id, err := ti.documentID(&testLogEntry{ID: "ptr-id"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if id != "ptr-id" {
+			t.Fatalf("expected id %q, got %q", "ptr-id", id)
+		}
+	})
+
+	t.Run("uses IDFunc as a last resort", func(t *testing.T) {
+		ti := &TypedBulkIndexer{IDFunc: func(doc interface{}) string { return "from-idfunc" }}
+
+		id, err := ti.documentID(struct{ Name string }{Name: "no id here"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if id != "from-idfunc" {
+			t.Fatalf("expected id %q, got %q", "from-idfunc", id)
+		}
+	})
+
+	t.Run("errors when no ID can be derived", func(t *testing.T) {
+		if _, err := ti.documentID(struct{ Name string }{Name: "no id here"}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("errors instead of panicking when the `es:\"id\"` tagged field is unexported", func(t *testing.T) {
+		if _, err := ti.documentID(testUnexportedIDDoc{id: "abc", Message: "hello"}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestTypedBulkIndexerResolveIndex(t *testing.T) {
+	ti, err := NewTypedBulkIndexer(TypedBulkIndexerConfig{Indexer: &fakeBulkIndexer{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ti.RegisterIndexResolver(testLogEntry{}, func(doc interface{}) (string, error) {
+		return "logs-resolved", nil
+	})
+
+	t.Run("resolves for a value registered by value", func(t *testing.T) {
+		index, err := ti.resolveIndex(testLogEntry{ID: "1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if index != "logs-resolved" {
+			t.Fatalf("expected index %q, got %q", "logs-resolved", index)
+		}
+	})
+
+	t.Run("resolves for a pointer to a value registered by value", func(t *testing.T) {
+		index, err := ti.resolveIndex(&testLogEntry{ID: "1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if index != "logs-resolved" {
+			t.Fatalf("expected index %q, got %q", "logs-resolved", index)
+		}
+	})
+
+	t.Run("returns an empty index when no resolver is registered", func(t *testing.T) {
+		index, err := ti.resolveIndex(testNoTagDoc{ID: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if index != "" {
+			t.Fatalf("expected an empty index, got %q", index)
+		}
+	})
+}
+
+// fakeBulkIndexer is a no-op BulkIndexer used to exercise TypedBulkIndexer in isolation.
+type fakeBulkIndexer struct{}
+
+func (*fakeBulkIndexer) Add(ctx context.Context, item BulkIndexerItem) error { return nil }
+func (*fakeBulkIndexer) Close(ctx context.Context) error                    { return nil }
+func (*fakeBulkIndexer) Stats() BulkIndexerStats                            { return BulkIndexerStats{} }